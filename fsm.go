@@ -1,10 +1,16 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
 	"encoding/json"
 	"fmt"
 	"io"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/hashicorp/raft"
 )
@@ -37,9 +43,108 @@ type UpdateJobStatusData struct {
 	NewStatus string `json:"new_status"`
 }
 
+// RegisterNodeData maps a peer's Raft address to the HTTP address it
+// actually serves its API on, so followers can forward or redirect writes
+// to the real leader endpoint instead of guessing a port offset.
+type RegisterNodeData struct {
+	RaftAddr string `json:"raft_addr"`
+	HTTPAddr string `json:"http_addr"`
+}
+
+// commandSchemaV1 is the only wire schema so far. It is written as a single
+// byte ahead of the gob-encoded command so a future schema bump can be
+// dispatched on without decoding the rest of the payload first.
+const commandSchemaV1 byte = 1
+
 type command struct {
-	Op   string          `json:"op,omitempty"`
-	Data json.RawMessage `json:"data,omitempty"`
+	SchemaVersion byte
+	Op            string
+	Data          []byte
+
+	// ClientID and RequestID together identify a single client-initiated
+	// write. When both are set, fsm.Apply dedupes retries of the same
+	// request (e.g. after a leader failover) instead of re-executing them.
+	ClientID  string
+	RequestID string
+}
+
+// encodeCommand serializes cmd into the Raft log wire format: a schema
+// version byte followed by a gob-encoded command. This replaces the old
+// whole-command JSON encoding, which allocated a map/struct per Apply.
+func encodeCommand(cmd command) ([]byte, error) {
+	cmd.SchemaVersion = commandSchemaV1
+	var buf bytes.Buffer
+	buf.WriteByte(commandSchemaV1)
+	if err := gob.NewEncoder(&buf).Encode(cmd); err != nil {
+		return nil, fmt.Errorf("failed to encode command: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeCommand is the inverse of encodeCommand. It rejects any schema
+// version it doesn't recognize rather than guessing at the layout.
+func decodeCommand(raw []byte) (command, error) {
+	if len(raw) < 1 {
+		return command{}, fmt.Errorf("empty command payload")
+	}
+	switch version := raw[0]; version {
+	case commandSchemaV1:
+		var cmd command
+		if err := gob.NewDecoder(bytes.NewReader(raw[1:])).Decode(&cmd); err != nil {
+			return command{}, fmt.Errorf("failed to decode schema v%d command: %w", version, err)
+		}
+		return cmd, nil
+	default:
+		return command{}, fmt.Errorf("unsupported command schema version %d", version)
+	}
+}
+
+// encodePayload gob-encodes a command's op-specific payload.
+func encodePayload(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, fmt.Errorf("failed to encode command payload: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decodePayload gob-decodes a command's op-specific payload into v.
+func decodePayload(data []byte, v any) error {
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode command payload: %w", err)
+	}
+	return nil
+}
+
+// --- Idempotent request dedup ---
+
+// dedupRetentionIndices and dedupMaxEntries bound the request ID LRU:
+// entries more than dedupRetentionIndices log entries behind the current
+// Raft log index are evicted first, and if the map is still over
+// dedupMaxEntries the entries with the oldest AppliedIndex are dropped too.
+// Eviction is keyed off the Raft log index rather than wall-clock time
+// because fsm.Apply runs independently on every replica for the same log
+// entry: wall-clock time taken inside Apply would let each node evict on
+// its own schedule and diverge, while the log index is identical everywhere
+// the entry is applied.
+const (
+	dedupRetentionIndices = 100000
+	dedupMaxEntries       = 10000
+)
+
+// dedupEntry records the outcome of a single client request so a retried
+// Apply of the same (ClientID, RequestID) can return it instead of
+// re-executing the command.
+type dedupEntry struct {
+	// AppliedIndex is the Raft log index the request was applied at. It
+	// replaces a wall-clock timestamp so every replica evicts identically.
+	AppliedIndex uint64
+	// ErrMsg is empty when the original Apply succeeded.
+	ErrMsg string
+}
+
+func dedupKey(clientID, requestID string) string {
+	return clientID + "|" + requestID
 }
 
 // --- FSM Struct and Methods ---
@@ -48,53 +153,144 @@ type fsmData struct {
 	Printers  map[string]Printer
 	Filaments map[string]Filament
 	PrintJobs map[string]PrintJob
+	// Dedup is replicated as part of fsmData so every follower converges on
+	// the same view of which requests have already been applied, including
+	// across snapshot/restore.
+	Dedup map[string]dedupEntry
+	// HTTPAddrs maps each peer's Raft address to its advertised HTTP address.
+	// It is replicated the same way as the rest of fsmData so every node,
+	// not just the leader, can resolve where to forward or redirect writes.
+	HTTPAddrs map[string]string
 }
 
 type fsm struct {
-	mu   sync.Mutex
+	mu sync.Mutex
+
 	data fsmData
+	// lastApplied is the wall-clock time of the most recent Apply, used to
+	// bound staleness on consistency=none reads.
+	lastApplied time.Time
+	// metrics records Apply outcomes/latency and snapshot counts for the
+	// /metrics endpoint. It is nil-safe so the FSM works without one.
+	metrics *Metrics
 }
 
-func newFSM() *fsm {
+func newFSM(metrics *Metrics) *fsm {
 	return &fsm{
 		data: fsmData{
 			Printers:  make(map[string]Printer),
 			Filaments: make(map[string]Filament),
 			PrintJobs: make(map[string]PrintJob),
+			Dedup:     make(map[string]dedupEntry),
+			HTTPAddrs: make(map[string]string),
 		},
+		metrics: metrics,
 	}
 }
 
 func (f *fsm) Apply(log *raft.Log) interface{} {
+	start := time.Now()
 	f.mu.Lock()
 	defer f.mu.Unlock()
+	f.lastApplied = time.Now()
+
+	cmd, err := decodeCommand(log.Data)
+	if err != nil {
+		if f.metrics != nil {
+			f.metrics.ObserveApply("decode_error", time.Since(start), true)
+		}
+		return fmt.Errorf("failed to decode command: %w", err)
+	}
+
+	dedupe := cmd.ClientID != "" && cmd.RequestID != ""
+	var key string
+	if dedupe {
+		key = dedupKey(cmd.ClientID, cmd.RequestID)
+		if entry, ok := f.data.Dedup[key]; ok {
+			if entry.ErrMsg != "" {
+				return fmt.Errorf("%s", entry.ErrMsg)
+			}
+			return nil
+		}
+	}
+
+	result := f.applyCommand(cmd)
 
-	var cmd command
-	if err := json.Unmarshal(log.Data, &cmd); err != nil {
-		return fmt.Errorf("failed to unmarshal command: %w", err)
+	if dedupe {
+		f.recordDedup(key, result, log.Index)
+	}
+	if f.metrics != nil {
+		_, failed := result.(error)
+		f.metrics.ObserveApply(cmd.Op, time.Since(start), failed)
 	}
+	return result
+}
 
+// recordDedup stores the outcome of a client request so a retried Apply of
+// the same (ClientID, RequestID) short-circuits instead of re-executing.
+// index is the Raft log index being applied, the same on every replica for
+// this entry. Callers must hold f.mu.
+func (f *fsm) recordDedup(key string, result interface{}, index uint64) {
+	entry := dedupEntry{AppliedIndex: index}
+	if err, ok := result.(error); ok {
+		entry.ErrMsg = err.Error()
+	}
+	f.data.Dedup[key] = entry
+	f.evictDedup(index)
+}
+
+// evictDedup drops entries more than dedupRetentionIndices behind
+// currentIndex, then trims down to dedupMaxEntries if the map is still over
+// capacity. It runs on every Apply that records a new entry, so the map
+// never grows unbounded. currentIndex is the Raft log index of the entry
+// that triggered eviction, so every replica makes the same decision.
+func (f *fsm) evictDedup(currentIndex uint64) {
+	for k, e := range f.data.Dedup {
+		if currentIndex > e.AppliedIndex && currentIndex-e.AppliedIndex > dedupRetentionIndices {
+			delete(f.data.Dedup, k)
+		}
+	}
+	if len(f.data.Dedup) <= dedupMaxEntries {
+		return
+	}
+	type aged struct {
+		key   string
+		index uint64
+	}
+	entries := make([]aged, 0, len(f.data.Dedup))
+	for k, e := range f.data.Dedup {
+		entries = append(entries, aged{k, e.AppliedIndex})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].index < entries[j].index })
+	for _, e := range entries[:len(entries)-dedupMaxEntries] {
+		delete(f.data.Dedup, e.key)
+	}
+}
+
+// applyCommand executes a decoded command against the FSM's state. Callers
+// must hold f.mu.
+func (f *fsm) applyCommand(cmd command) interface{} {
 	switch cmd.Op {
 	case "add_printer":
 		var p Printer
-		if err := json.Unmarshal(cmd.Data, &p); err != nil {
-			return fmt.Errorf("failed to unmarshal printer data: %w", err)
+		if err := decodePayload(cmd.Data, &p); err != nil {
+			return fmt.Errorf("failed to decode printer data: %w", err)
 		}
 		f.data.Printers[p.ID] = p
 		return nil
 
 	case "add_filament":
 		var filament Filament
-		if err := json.Unmarshal(cmd.Data, &filament); err != nil {
-			return fmt.Errorf("failed to unmarshal filament data: %w", err)
+		if err := decodePayload(cmd.Data, &filament); err != nil {
+			return fmt.Errorf("failed to decode filament data: %w", err)
 		}
 		f.data.Filaments[filament.ID] = filament
 		return nil
 
 	case "add_print_job":
 		var job PrintJob
-		if err := json.Unmarshal(cmd.Data, &job); err != nil {
-			return fmt.Errorf("failed to unmarshal print job data: %w", err)
+		if err := decodePayload(cmd.Data, &job); err != nil {
+			return fmt.Errorf("failed to decode print job data: %w", err)
 		}
 
 		if _, ok := f.data.Printers[job.PrinterID]; !ok {
@@ -123,8 +319,8 @@ func (f *fsm) Apply(log *raft.Log) interface{} {
 
 	case "update_job_status":
 		var updateData UpdateJobStatusData
-		if err := json.Unmarshal(cmd.Data, &updateData); err != nil {
-			return fmt.Errorf("failed to unmarshal update job data: %w", err)
+		if err := decodePayload(cmd.Data, &updateData); err != nil {
+			return fmt.Errorf("failed to decode update job data: %w", err)
 		}
 
 		job, ok := f.data.PrintJobs[updateData.JobID]
@@ -162,11 +358,66 @@ func (f *fsm) Apply(log *raft.Log) interface{} {
 		f.data.PrintJobs[job.ID] = job
 		return nil
 
+	case "restore":
+		var newData fsmData
+		if err := json.Unmarshal(cmd.Data, &newData); err != nil {
+			return fmt.Errorf("failed to unmarshal restore snapshot: %w", err)
+		}
+		for _, job := range f.data.PrintJobs {
+			if job.Status == "Running" {
+				return fmt.Errorf("cannot restore: print job %s is currently Running", job.ID)
+			}
+		}
+		if newData.Printers == nil {
+			newData.Printers = make(map[string]Printer)
+		}
+		if newData.Filaments == nil {
+			newData.Filaments = make(map[string]Filament)
+		}
+		if newData.PrintJobs == nil {
+			newData.PrintJobs = make(map[string]PrintJob)
+		}
+		if newData.Dedup == nil {
+			newData.Dedup = make(map[string]dedupEntry)
+		}
+		if newData.HTTPAddrs == nil {
+			newData.HTTPAddrs = make(map[string]string)
+		}
+		f.data = newData
+		return nil
+
+	case "register_node":
+		var reg RegisterNodeData
+		if err := decodePayload(cmd.Data, &reg); err != nil {
+			return fmt.Errorf("failed to decode register_node data: %w", err)
+		}
+		f.data.HTTPAddrs[reg.RaftAddr] = reg.HTTPAddr
+		return nil
+
 	default:
 		return fmt.Errorf("unrecognized command op: %s", cmd.Op)
 	}
 }
 
+// LastAppliedTime reports when the FSM last applied a Raft log entry. GET
+// handlers serving consistency=none reads use it to reject responses that
+// are older than the caller's requested max-stale bound.
+func (f *fsm) LastAppliedTime() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lastApplied
+}
+
+// HTTPAddrFor looks up the advertised HTTP address a peer registered for
+// the given Raft address, so callers can forward or redirect writes to the
+// leader's real API endpoint instead of guessing one.
+func (f *fsm) HTTPAddrFor(raftAddr string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	addr, ok := f.data.HTTPAddrs[raftAddr]
+	return addr, ok
+}
+
 // --- Snapshot and Restore Methods ---
 func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
 	f.mu.Lock()
@@ -175,6 +426,8 @@ func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
 		Printers:  make(map[string]Printer),
 		Filaments: make(map[string]Filament),
 		PrintJobs: make(map[string]PrintJob),
+		Dedup:     make(map[string]dedupEntry),
+		HTTPAddrs: make(map[string]string),
 	}
 	for k, v := range f.data.Printers {
 		clone.Printers[k] = v
@@ -185,14 +438,157 @@ func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
 	for k, v := range f.data.PrintJobs {
 		clone.PrintJobs[k] = v
 	}
+	for k, v := range f.data.Dedup {
+		clone.Dedup[k] = v
+	}
+	for k, v := range f.data.HTTPAddrs {
+		clone.HTTPAddrs[k] = v
+	}
+	if f.metrics != nil {
+		f.metrics.IncSnapshots()
+	}
 	return &fsmSnapshot{data: clone}, nil
 }
 
+// snapshotMagic prefixes the streaming record format introduced alongside
+// the binary command encoding. It can never collide with the old format's
+// first byte, which was always '{' (0x7B) since it whole-map JSON-encoded
+// fsmData.
+const snapshotMagic byte = 0xF5
+const snapshotSchemaV1 byte = 1
+
+const (
+	recordTypePrinter byte = iota + 1
+	recordTypeFilament
+	recordTypePrintJob
+	recordTypeDedup
+	recordTypeHTTPAddr
+)
+
+// dedupRecord is the on-disk shape of one Dedup map entry, since gob can't
+// encode a bare map key/value pair as a standalone record.
+type dedupRecord struct {
+	Key   string
+	Entry dedupEntry
+}
+
+// httpAddrRecord is the on-disk shape of one HTTPAddrs map entry, since gob
+// can't encode a bare map key/value pair as a standalone record.
+type httpAddrRecord struct {
+	RaftAddr string
+	HTTPAddr string
+}
+
 func (f *fsm) Restore(rc io.ReadCloser) error {
 	defer rc.Close()
+	br := bufio.NewReader(rc)
+	first, err := br.Peek(1)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	if len(first) == 1 && first[0] == snapshotMagic {
+		return f.restoreStreaming(br)
+	}
+	return f.restoreLegacyJSON(br)
+}
+
+// restoreStreaming decodes the current length-prefixed record format, which
+// lets Restore decode one record at a time instead of loading the whole
+// snapshot into memory twice the way the old whole-map JSON encoding did.
+func (f *fsm) restoreStreaming(r *bufio.Reader) error {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return fmt.Errorf("failed to read snapshot header: %w", err)
+	}
+	if header[1] != snapshotSchemaV1 {
+		return fmt.Errorf("unsupported snapshot schema version %d", header[1])
+	}
+
+	data := fsmData{
+		Printers:  make(map[string]Printer),
+		Filaments: make(map[string]Filament),
+		PrintJobs: make(map[string]PrintJob),
+		Dedup:     make(map[string]dedupEntry),
+		HTTPAddrs: make(map[string]string),
+	}
+	recHeader := make([]byte, 5)
+	for {
+		if _, err := io.ReadFull(r, recHeader); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return fmt.Errorf("failed to read snapshot record header: %w", err)
+		}
+		recordType := recHeader[0]
+		length := binary.BigEndian.Uint32(recHeader[1:])
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return fmt.Errorf("failed to read snapshot record body: %w", err)
+		}
+		dec := gob.NewDecoder(bytes.NewReader(body))
+		switch recordType {
+		case recordTypePrinter:
+			var p Printer
+			if err := dec.Decode(&p); err != nil {
+				return fmt.Errorf("failed to decode printer record: %w", err)
+			}
+			data.Printers[p.ID] = p
+		case recordTypeFilament:
+			var filament Filament
+			if err := dec.Decode(&filament); err != nil {
+				return fmt.Errorf("failed to decode filament record: %w", err)
+			}
+			data.Filaments[filament.ID] = filament
+		case recordTypePrintJob:
+			var job PrintJob
+			if err := dec.Decode(&job); err != nil {
+				return fmt.Errorf("failed to decode print job record: %w", err)
+			}
+			data.PrintJobs[job.ID] = job
+		case recordTypeDedup:
+			var rec dedupRecord
+			if err := dec.Decode(&rec); err != nil {
+				return fmt.Errorf("failed to decode dedup record: %w", err)
+			}
+			data.Dedup[rec.Key] = rec.Entry
+		case recordTypeHTTPAddr:
+			var rec httpAddrRecord
+			if err := dec.Decode(&rec); err != nil {
+				return fmt.Errorf("failed to decode http addr record: %w", err)
+			}
+			data.HTTPAddrs[rec.RaftAddr] = rec.HTTPAddr
+		default:
+			return fmt.Errorf("unrecognized snapshot record type %d", recordType)
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.data = data
+	return nil
+}
+
+// restoreLegacyJSON upgrades a snapshot written before the binary format
+// landed, when Persist whole-map JSON-encoded fsmData in one shot.
+func (f *fsm) restoreLegacyJSON(r io.Reader) error {
 	var data fsmData
-	if err := json.NewDecoder(rc).Decode(&data); err != nil {
-		return err
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return fmt.Errorf("failed to decode legacy snapshot: %w", err)
+	}
+	if data.Printers == nil {
+		data.Printers = make(map[string]Printer)
+	}
+	if data.Filaments == nil {
+		data.Filaments = make(map[string]Filament)
+	}
+	if data.PrintJobs == nil {
+		data.PrintJobs = make(map[string]PrintJob)
+	}
+	if data.Dedup == nil {
+		data.Dedup = make(map[string]dedupEntry)
+	}
+	if data.HTTPAddrs == nil {
+		data.HTTPAddrs = make(map[string]string)
 	}
 	f.mu.Lock()
 	defer f.mu.Unlock()
@@ -204,12 +600,41 @@ type fsmSnapshot struct {
 	data fsmData
 }
 
+// Persist streams fsmData out as a sequence of length-prefixed gob records
+// instead of encoding the whole map as one JSON document, so memory use is
+// bounded by a single record rather than the full snapshot.
 func (s *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
 	err := func() error {
-		if err := json.NewEncoder(sink).Encode(s.data); err != nil {
+		w := bufio.NewWriter(sink)
+		if _, err := w.Write([]byte{snapshotMagic, snapshotSchemaV1}); err != nil {
 			return err
 		}
-		return nil
+		for _, p := range s.data.Printers {
+			if err := writeSnapshotRecord(w, recordTypePrinter, p); err != nil {
+				return err
+			}
+		}
+		for _, filament := range s.data.Filaments {
+			if err := writeSnapshotRecord(w, recordTypeFilament, filament); err != nil {
+				return err
+			}
+		}
+		for _, job := range s.data.PrintJobs {
+			if err := writeSnapshotRecord(w, recordTypePrintJob, job); err != nil {
+				return err
+			}
+		}
+		for key, entry := range s.data.Dedup {
+			if err := writeSnapshotRecord(w, recordTypeDedup, dedupRecord{Key: key, Entry: entry}); err != nil {
+				return err
+			}
+		}
+		for raftAddr, httpAddr := range s.data.HTTPAddrs {
+			if err := writeSnapshotRecord(w, recordTypeHTTPAddr, httpAddrRecord{RaftAddr: raftAddr, HTTPAddr: httpAddr}); err != nil {
+				return err
+			}
+		}
+		return w.Flush()
 	}()
 
 	if err != nil {
@@ -218,4 +643,19 @@ func (s *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
 	return err
 }
 
+func writeSnapshotRecord(w io.Writer, recordType byte, v any) error {
+	body, err := encodePayload(v)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, 5)
+	header[0] = recordType
+	binary.BigEndian.PutUint32(header[1:], uint32(len(body)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err = w.Write(body)
+	return err
+}
+
 func (s *fsmSnapshot) Release() {}
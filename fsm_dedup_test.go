@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func applyTestCommand(t *testing.T, f *fsm, cmd command) interface{} {
+	t.Helper()
+	raw, err := encodeCommand(cmd)
+	if err != nil {
+		t.Fatalf("encodeCommand returned error: %v", err)
+	}
+	return f.Apply(&raft.Log{Data: raw})
+}
+
+func TestFSMApplyDedupesRetriedRequest(t *testing.T) {
+	f := newFSM(nil)
+	payload, err := encodePayload(Printer{ID: "p1", Name: "Prusa MK4"})
+	if err != nil {
+		t.Fatalf("encodePayload returned error: %v", err)
+	}
+	cmd := command{Op: "add_printer", Data: payload, ClientID: "client-a", RequestID: "req-1"}
+
+	if result := applyTestCommand(t, f, cmd); result != nil {
+		t.Fatalf("first apply returned unexpected error: %v", result)
+	}
+	delete(f.data.Printers, "p1")
+
+	if result := applyTestCommand(t, f, cmd); result != nil {
+		t.Fatalf("retried apply returned unexpected error: %v", result)
+	}
+	if _, ok := f.data.Printers["p1"]; ok {
+		t.Fatal("retried apply re-executed the command instead of returning the cached result")
+	}
+}
+
+func TestFSMApplyDedupesRetriedFailure(t *testing.T) {
+	f := newFSM(nil)
+	payload, err := encodePayload(PrintJob{ID: "j1", PrinterID: "missing-printer", FilamentID: "missing-filament", GramsNeeded: 10})
+	if err != nil {
+		t.Fatalf("encodePayload returned error: %v", err)
+	}
+	cmd := command{Op: "add_print_job", Data: payload, ClientID: "client-a", RequestID: "req-2"}
+
+	first := applyTestCommand(t, f, cmd)
+	firstErr, ok := first.(error)
+	if !ok {
+		t.Fatalf("expected first apply to fail, got %v", first)
+	}
+
+	second := applyTestCommand(t, f, cmd)
+	secondErr, ok := second.(error)
+	if !ok {
+		t.Fatalf("expected retried apply to return the cached failure, got %v", second)
+	}
+	if firstErr.Error() != secondErr.Error() {
+		t.Fatalf("cached error mismatch: first %q, second %q", firstErr, secondErr)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestFSMApplyRegisterNodeRecordsHTTPAddr(t *testing.T) {
+	f := newFSM(nil)
+	payload, err := encodePayload(RegisterNodeData{RaftAddr: "127.0.0.1:7000", HTTPAddr: "127.0.0.1:8000"})
+	if err != nil {
+		t.Fatalf("encodePayload returned error: %v", err)
+	}
+	cmd := command{Op: "register_node", Data: payload}
+
+	if result := applyTestCommand(t, f, cmd); result != nil {
+		t.Fatalf("register_node apply returned unexpected error: %v", result)
+	}
+
+	got, ok := f.HTTPAddrFor("127.0.0.1:7000")
+	if !ok || got != "127.0.0.1:8000" {
+		t.Fatalf("HTTPAddrFor = (%q, %v), want (%q, true)", got, ok, "127.0.0.1:8000")
+	}
+}
+
+func TestFSMApplyRegisterNodeOverwritesPriorAddr(t *testing.T) {
+	f := newFSM(nil)
+	first, err := encodePayload(RegisterNodeData{RaftAddr: "127.0.0.1:7000", HTTPAddr: "127.0.0.1:8000"})
+	if err != nil {
+		t.Fatalf("encodePayload returned error: %v", err)
+	}
+	applyTestCommand(t, f, command{Op: "register_node", Data: first})
+
+	second, err := encodePayload(RegisterNodeData{RaftAddr: "127.0.0.1:7000", HTTPAddr: "127.0.0.1:9000"})
+	if err != nil {
+		t.Fatalf("encodePayload returned error: %v", err)
+	}
+	applyTestCommand(t, f, command{Op: "register_node", Data: second})
+
+	got, ok := f.HTTPAddrFor("127.0.0.1:7000")
+	if !ok || got != "127.0.0.1:9000" {
+		t.Fatalf("HTTPAddrFor = (%q, %v), want (%q, true)", got, ok, "127.0.0.1:9000")
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// restoreCommand builds the same command{Op:"restore"} envelope
+// Store.Restore sends through Raft, so these tests exercise the replicated
+// apply path rather than fsm.Restore's direct snapshot-loading path (covered
+// by fsm_migration_test.go).
+func restoreCommand(t *testing.T, data fsmData) command {
+	t.Helper()
+	payload, err := json.Marshal(data)
+	if err != nil {
+		t.Fatalf("failed to marshal restore payload: %v", err)
+	}
+	return command{Op: "restore", Data: payload}
+}
+
+func TestFSMApplyRestoreReplacesState(t *testing.T) {
+	f := newFSM(nil)
+	f.data.Printers["stale"] = Printer{ID: "stale", Name: "Old Printer"}
+
+	want := fsmData{
+		Printers: map[string]Printer{"p1": {ID: "p1", Name: "Prusa MK4"}},
+	}
+	cmd := restoreCommand(t, want)
+
+	if result := applyTestCommand(t, f, cmd); result != nil {
+		t.Fatalf("restore apply returned unexpected error: %v", result)
+	}
+	if _, ok := f.data.Printers["stale"]; ok {
+		t.Fatal("restore did not replace the prior state")
+	}
+	if got := f.data.Printers["p1"]; got != want.Printers["p1"] {
+		t.Errorf("printer mismatch: got %+v, want %+v", got, want.Printers["p1"])
+	}
+}
+
+func TestFSMApplyRestoreRejectsWhileJobRunning(t *testing.T) {
+	f := newFSM(nil)
+	f.data.PrintJobs["j1"] = PrintJob{ID: "j1", Status: "Running"}
+
+	cmd := restoreCommand(t, fsmData{})
+
+	result := applyTestCommand(t, f, cmd)
+	if _, ok := result.(error); !ok {
+		t.Fatalf("expected restore to be rejected while a job is Running, got %v", result)
+	}
+	if _, ok := f.data.PrintJobs["j1"]; !ok {
+		t.Fatal("restore mutated state despite being rejected")
+	}
+}
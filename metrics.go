@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// applyLatencyBucketsSeconds are the histogram bucket upper bounds (in
+// seconds) reported by fsm_apply_duration_seconds.
+var applyLatencyBucketsSeconds = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+type opCounters struct {
+	applied uint64
+	errors  uint64
+}
+
+// Metrics accumulates the counters and histogram the /metrics endpoint
+// renders in Prometheus text format. All methods are safe for concurrent
+// use; the op-keyed map and the latency histogram are guarded by mu, while
+// the scalar counters use atomics. ObserveApply and Render both take mu.
+type Metrics struct {
+	mu         sync.Mutex
+	opCounters map[string]*opCounters
+
+	// applyLatencyBuckets holds cumulative counts for each bound in
+	// applyLatencyBucketsSeconds, plus one trailing +Inf bucket.
+	applyLatencyBuckets []uint64
+	applyLatencyCount   uint64
+	applyLatencySum     float64
+
+	leaderElections  uint64
+	failedHeartbeats uint64
+	snapshots        uint64
+}
+
+func newMetrics() *Metrics {
+	return &Metrics{
+		opCounters:          make(map[string]*opCounters),
+		applyLatencyBuckets: make([]uint64, len(applyLatencyBucketsSeconds)+1),
+	}
+}
+
+func (m *Metrics) countersFor(op string) *opCounters {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.opCounters[op]
+	if !ok {
+		c = &opCounters{}
+		m.opCounters[op] = c
+	}
+	return c
+}
+
+// ObserveApply records the outcome and latency of one fsm.Apply call.
+func (m *Metrics) ObserveApply(op string, d time.Duration, failed bool) {
+	c := m.countersFor(op)
+	atomic.AddUint64(&c.applied, 1)
+	if failed {
+		atomic.AddUint64(&c.errors, 1)
+	}
+
+	seconds := d.Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.applyLatencyCount++
+	m.applyLatencySum += seconds
+	for i, bound := range applyLatencyBucketsSeconds {
+		if seconds <= bound {
+			m.applyLatencyBuckets[i]++
+		}
+	}
+	m.applyLatencyBuckets[len(applyLatencyBucketsSeconds)]++ // +Inf
+}
+
+func (m *Metrics) IncLeaderElections()  { atomic.AddUint64(&m.leaderElections, 1) }
+func (m *Metrics) IncFailedHeartbeats() { atomic.AddUint64(&m.failedHeartbeats, 1) }
+func (m *Metrics) IncSnapshots()        { atomic.AddUint64(&m.snapshots, 1) }
+
+// Render writes every accumulated metric, plus the Raft gauges pulled from
+// raft.Stats(), in Prometheus text exposition format.
+func (m *Metrics) Render(w io.Writer, raftStats map[string]string) error {
+	gaugeFromStats := func(name, statsKey string) {
+		value := raftStats[statsKey]
+		if value == "" {
+			value = "0"
+		}
+		fmt.Fprintf(w, "# TYPE %s gauge\n%s %s\n", name, name, value)
+	}
+
+	fmt.Fprintf(w, "# HELP raft_state 1 for the node's current Raft state, labeled by state name.\n")
+	fmt.Fprintf(w, "# TYPE raft_state gauge\n")
+	for _, state := range []string{"Follower", "Candidate", "Leader", "Shutdown"} {
+		value := 0
+		if raftStats["state"] == state {
+			value = 1
+		}
+		fmt.Fprintf(w, "raft_state{state=%q} %d\n", state, value)
+	}
+
+	fmt.Fprintf(w, "# HELP raft_term Current Raft term.\n")
+	gaugeFromStats("raft_term", "term")
+	fmt.Fprintf(w, "# HELP raft_last_log_index Index of the last entry in the Raft log.\n")
+	gaugeFromStats("raft_last_log_index", "last_log_index")
+	fmt.Fprintf(w, "# HELP raft_commit_index Index of the last committed Raft log entry.\n")
+	gaugeFromStats("raft_commit_index", "commit_index")
+	fmt.Fprintf(w, "# HELP raft_last_applied Index of the last log entry applied to the FSM.\n")
+	gaugeFromStats("raft_last_applied", "applied_index")
+
+	fmt.Fprintf(w, "# HELP raft_leader_elections_total Number of leadership changes observed by this node.\n")
+	fmt.Fprintf(w, "# TYPE raft_leader_elections_total counter\nraft_leader_elections_total %d\n", atomic.LoadUint64(&m.leaderElections))
+
+	fmt.Fprintf(w, "# HELP raft_failed_heartbeats_total Number of failed heartbeats observed by this node.\n")
+	fmt.Fprintf(w, "# TYPE raft_failed_heartbeats_total counter\nraft_failed_heartbeats_total %d\n", atomic.LoadUint64(&m.failedHeartbeats))
+
+	fmt.Fprintf(w, "# HELP raft_snapshots_total Number of snapshots this node has taken.\n")
+	fmt.Fprintf(w, "# TYPE raft_snapshots_total counter\nraft_snapshots_total %d\n", atomic.LoadUint64(&m.snapshots))
+
+	m.mu.Lock()
+	ops := make([]string, 0, len(m.opCounters))
+	for op := range m.opCounters {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+
+	fmt.Fprintf(w, "# HELP fsm_apply_total Number of FSM.Apply calls, labeled by op.\n")
+	fmt.Fprintf(w, "# TYPE fsm_apply_total counter\n")
+	for _, op := range ops {
+		fmt.Fprintf(w, "fsm_apply_total{op=%q} %d\n", op, atomic.LoadUint64(&m.opCounters[op].applied))
+	}
+
+	fmt.Fprintf(w, "# HELP fsm_apply_errors_total Number of FSM.Apply calls that returned an error, labeled by op.\n")
+	fmt.Fprintf(w, "# TYPE fsm_apply_errors_total counter\n")
+	for _, op := range ops {
+		fmt.Fprintf(w, "fsm_apply_errors_total{op=%q} %d\n", op, atomic.LoadUint64(&m.opCounters[op].errors))
+	}
+
+	fmt.Fprintf(w, "# HELP fsm_apply_duration_seconds Histogram of FSM.Apply latency.\n")
+	fmt.Fprintf(w, "# TYPE fsm_apply_duration_seconds histogram\n")
+	for i, bound := range applyLatencyBucketsSeconds {
+		fmt.Fprintf(w, "fsm_apply_duration_seconds_bucket{le=%q} %d\n", strconv.FormatFloat(bound, 'g', -1, 64), m.applyLatencyBuckets[i])
+	}
+	fmt.Fprintf(w, "fsm_apply_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.applyLatencyBuckets[len(applyLatencyBucketsSeconds)])
+	fmt.Fprintf(w, "fsm_apply_duration_seconds_sum %s\n", strconv.FormatFloat(m.applyLatencySum, 'f', -1, 64))
+	fmt.Fprintf(w, "fsm_apply_duration_seconds_count %d\n", m.applyLatencyCount)
+	m.mu.Unlock()
+
+	return nil
+}
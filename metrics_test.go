@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsRenderIncludesApplyCounts(t *testing.T) {
+	m := newMetrics()
+	m.ObserveApply("add_printer", 2*time.Millisecond, false)
+	m.ObserveApply("add_printer", 3*time.Millisecond, true)
+	m.IncLeaderElections()
+	m.IncSnapshots()
+
+	var buf bytes.Buffer
+	if err := m.Render(&buf, map[string]string{"state": "Leader", "term": "4"}); err != nil {
+		t.Fatalf("Render returned error: %v", err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		`fsm_apply_total{op="add_printer"} 2`,
+		`fsm_apply_errors_total{op="add_printer"} 1`,
+		`raft_state{state="Leader"} 1`,
+		`raft_leader_elections_total 1`,
+		`raft_snapshots_total 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, out)
+		}
+	}
+}
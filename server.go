@@ -3,11 +3,13 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
-	"net"
 	"net/http"
-	"strconv"
+	"net/http/httputil"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/raft"
 )
@@ -15,12 +17,16 @@ import (
 type Server struct {
 	store    *Store
 	httpAddr string
+	// forwardWrites, when set, makes non-leader nodes reverse-proxy write
+	// requests to the leader instead of responding with an HTTP redirect.
+	forwardWrites bool
 }
 
-func NewServer(addr string, store *Store) *Server {
+func NewServer(addr string, store *Store, forwardWrites bool) *Server {
 	return &Server{
-		store:    store,
-		httpAddr: addr,
+		store:         store,
+		httpAddr:      addr,
+		forwardWrites: forwardWrites,
 	}
 }
 
@@ -32,32 +38,171 @@ func (s *Server) Start() error {
 	mux.HandleFunc("/filaments", s.handleFilaments)
 	mux.HandleFunc("/print_jobs", s.handlePrintJobs)
 	mux.HandleFunc("/print_jobs/", s.handleUpdateJobStatus)
+	mux.HandleFunc("/backup", s.handleBackup)
+	mux.HandleFunc("/restore", s.handleRestore)
+	mux.HandleFunc("/remove", s.handleRemove)
+	mux.HandleFunc("/demote", s.handleDemote)
+	mux.HandleFunc("/cluster", s.handleCluster)
+	mux.HandleFunc("/metrics", s.handleMetrics)
 
 	log.Printf("HTTP server listening on %s\n", s.httpAddr)
 	return http.ListenAndServe(s.httpAddr, mux)
 }
 
-// --- Helper for redirection ---
-func (s *Server) redirectToLeader(w http.ResponseWriter, r *http.Request) {
+// --- Helpers for routing writes away from non-leader nodes ---
+
+// leaderHTTPAddr resolves the current Raft leader's advertised HTTP address,
+// as published by RegisterNode. It fails if there is no leader yet or the
+// leader hasn't registered its HTTP address yet (e.g. just elected).
+func (s *Server) leaderHTTPAddr() (string, error) {
 	leaderRaftAddr := string(s.store.raft.Leader())
 	if leaderRaftAddr == "" {
-		http.Error(w, "No leader found", http.StatusServiceUnavailable)
+		return "", fmt.Errorf("no leader found")
+	}
+	httpAddr, ok := s.store.HTTPAddrFor(leaderRaftAddr)
+	if !ok {
+		return "", fmt.Errorf("leader %s has not registered an HTTP address yet", leaderRaftAddr)
+	}
+	return httpAddr, nil
+}
+
+// redirectToLeader responds with an HTTP redirect pointing the client at the
+// leader's real HTTP address, so it can retry the write itself.
+func (s *Server) redirectToLeader(w http.ResponseWriter, r *http.Request) {
+	leaderHTTPAddr, err := s.leaderHTTPAddr()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
 		return
 	}
-	host, raftPortStr, err := net.SplitHostPort(leaderRaftAddr)
+	redirectURL := fmt.Sprintf("http://%s%s?%s", leaderHTTPAddr, r.URL.Path, r.URL.RawQuery)
+	log.Printf("I am not the leader. Redirecting request to leader at %s", redirectURL)
+	http.Redirect(w, r, redirectURL, http.StatusTemporaryRedirect)
+}
+
+// forwardToLeader reverse-proxies the request to the leader and streams its
+// response back, so the client never has to know it hit the wrong node.
+func (s *Server) forwardToLeader(w http.ResponseWriter, r *http.Request) {
+	leaderHTTPAddr, err := s.leaderHTTPAddr()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	target, err := url.Parse("http://" + leaderHTTPAddr)
 	if err != nil {
 		http.Error(w, "Failed to parse leader address", http.StatusInternalServerError)
 		return
 	}
-	raftPort, err := strconv.Atoi(raftPortStr)
+	log.Printf("I am not the leader. Forwarding request to leader at %s", target)
+	httputil.NewSingleHostReverseProxy(target).ServeHTTP(w, r)
+}
+
+// handleNonLeader routes a request away from this node when it isn't the
+// Raft leader, either by forwarding it (-forward-writes) or redirecting the
+// client to retry against the leader itself. It reports whether it handled
+// the request, in which case the caller must not write anything else.
+func (s *Server) handleNonLeader(w http.ResponseWriter, r *http.Request) bool {
+	if s.store.raft.State() == raft.Leader {
+		return false
+	}
+	if s.forwardWrites {
+		s.forwardToLeader(w, r)
+	} else {
+		s.redirectToLeader(w, r)
+	}
+	return true
+}
+
+// --- Read consistency helpers ---
+
+// parseConsistency reads the `consistency` query parameter, defaulting to
+// ConsistencyWeak when absent.
+func parseConsistency(r *http.Request) (ConsistencyLevel, error) {
+	switch level := ConsistencyLevel(r.URL.Query().Get("consistency")); level {
+	case "":
+		return ConsistencyWeak, nil
+	case ConsistencyNone, ConsistencyWeak, ConsistencyStrong:
+		return level, nil
+	default:
+		return "", fmt.Errorf("unknown consistency level %q", level)
+	}
+}
+
+// parseMaxStale reads the `max_stale` query parameter, which only applies to
+// consistency=none reads. ok is false when the caller didn't set a bound.
+func parseMaxStale(r *http.Request) (d time.Duration, ok bool, err error) {
+	raw := r.URL.Query().Get("max_stale")
+	if raw == "" {
+		return 0, false, nil
+	}
+	d, err = time.ParseDuration(raw)
 	if err != nil {
-		http.Error(w, "Failed to parse leader port", http.StatusInternalServerError)
+		return 0, false, fmt.Errorf("invalid max_stale duration %q: %w", raw, err)
+	}
+	return d, true, nil
+}
+
+// handleConsistentGet serves a GET endpoint under the requested consistency
+// level, redirecting non-leaders away from weak/strong reads and rejecting
+// none reads that are older than an optional max_stale bound.
+func (s *Server) handleConsistentGet(w http.ResponseWriter, r *http.Request, errLabel string, fn func() any) {
+	level, err := parseConsistency(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	httpPort := raftPort + 1000
-	redirectURL := fmt.Sprintf("http://%s:%d%s?%s", host, httpPort, r.URL.Path, r.URL.RawQuery)
-	log.Printf("I am not the leader. Redirecting request to leader at %s", redirectURL)
-	http.Redirect(w, r, redirectURL, http.StatusTemporaryRedirect)
+
+	if level == ConsistencyNone {
+		if maxStale, ok, err := parseMaxStale(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if ok {
+			if age := time.Since(s.store.fsm.LastAppliedTime()); age > maxStale {
+				http.Error(w, fmt.Sprintf("stale read: last applied %s ago exceeds max_stale %s", age, maxStale), http.StatusServiceUnavailable)
+				return
+			}
+		}
+	} else if s.store.raft.State() != raft.Leader {
+		s.redirectToLeader(w, r)
+		return
+	}
+
+	result, err := s.store.ConsistentRead(level, fn)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode %s", errLabel), http.StatusInternalServerError)
+	}
+}
+
+// --- Idempotent write helpers ---
+
+// decodeWriteBody reads the request body into v and extracts the client's
+// idempotency key, so a retried write (e.g. after a leader failover) can be
+// deduped instead of re-applied. The client and request IDs come from the
+// X-Client-ID/X-Request-ID headers, falling back to a top-level
+// "request_id" field in the JSON body for RequestID.
+func decodeWriteBody(r *http.Request, v any) (clientID, requestID string, err error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read request body: %w", err)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return "", "", fmt.Errorf("failed to decode request body: %w", err)
+	}
+	var meta struct {
+		RequestID string `json:"request_id"`
+	}
+	_ = json.Unmarshal(body, &meta)
+
+	clientID = r.Header.Get("X-Client-ID")
+	requestID = r.Header.Get("X-Request-ID")
+	if requestID == "" {
+		requestID = meta.RequestID
+	}
+	return clientID, requestID, nil
 }
 
 // --- Handlers ---
@@ -74,26 +219,31 @@ func (s *Server) handlePrinters(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleGetPrinters(w http.ResponseWriter, r *http.Request) {
-	printers := s.store.GetPrinters()
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(printers); err != nil {
-		http.Error(w, "Failed to encode printers", http.StatusInternalServerError)
-	}
+	s.handleConsistentGet(w, r, "printers", func() any {
+		return s.store.GetPrinters()
+	})
 }
 
 func (s *Server) handleAddPrinter(w http.ResponseWriter, r *http.Request) {
-	if s.store.raft.State() != raft.Leader {
-		s.redirectToLeader(w, r)
+	if s.handleNonLeader(w, r) {
 		return
 	}
 	var p Printer
-	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
-		http.Error(w, "Failed to decode printer from request", http.StatusBadRequest)
+	clientID, requestID, err := decodeWriteBody(r, &p)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cmdData, err := encodePayload(p)
+	if err != nil {
+		http.Error(w, "Failed to encode printer command", http.StatusInternalServerError)
+		return
+	}
+	cmdBytes, err := encodeCommand(command{Op: "add_printer", Data: cmdData, ClientID: clientID, RequestID: requestID})
+	if err != nil {
+		http.Error(w, "Failed to encode command", http.StatusInternalServerError)
 		return
 	}
-	cmdData, _ := json.Marshal(p)
-	cmd := command{Op: "add_printer", Data: cmdData}
-	cmdBytes, _ := json.Marshal(cmd)
 	resp, err := s.store.Apply(cmdBytes)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -118,26 +268,31 @@ func (s *Server) handleFilaments(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleGetFilaments(w http.ResponseWriter, r *http.Request) {
-	filaments := s.store.GetFilaments()
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(filaments); err != nil {
-		http.Error(w, "Failed to encode filaments", http.StatusInternalServerError)
-	}
+	s.handleConsistentGet(w, r, "filaments", func() any {
+		return s.store.GetFilaments()
+	})
 }
 
 func (s *Server) handleAddFilament(w http.ResponseWriter, r *http.Request) {
-	if s.store.raft.State() != raft.Leader {
-		s.redirectToLeader(w, r)
+	if s.handleNonLeader(w, r) {
 		return
 	}
 	var f Filament
-	if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
-		http.Error(w, "Failed to decode filament from request", http.StatusBadRequest)
+	clientID, requestID, err := decodeWriteBody(r, &f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cmdData, err := encodePayload(f)
+	if err != nil {
+		http.Error(w, "Failed to encode filament command", http.StatusInternalServerError)
+		return
+	}
+	cmdBytes, err := encodeCommand(command{Op: "add_filament", Data: cmdData, ClientID: clientID, RequestID: requestID})
+	if err != nil {
+		http.Error(w, "Failed to encode command", http.StatusInternalServerError)
 		return
 	}
-	cmdData, _ := json.Marshal(f)
-	cmd := command{Op: "add_filament", Data: cmdData}
-	cmdBytes, _ := json.Marshal(cmd)
 	resp, err := s.store.Apply(cmdBytes)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -162,26 +317,31 @@ func (s *Server) handlePrintJobs(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleGetPrintJobs(w http.ResponseWriter, r *http.Request) {
-	jobs := s.store.GetPrintJobs()
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(jobs); err != nil {
-		http.Error(w, "Failed to encode jobs", http.StatusInternalServerError)
-	}
+	s.handleConsistentGet(w, r, "jobs", func() any {
+		return s.store.GetPrintJobs()
+	})
 }
 
 func (s *Server) handleAddPrintJob(w http.ResponseWriter, r *http.Request) {
-	if s.store.raft.State() != raft.Leader {
-		s.redirectToLeader(w, r)
+	if s.handleNonLeader(w, r) {
 		return
 	}
 	var job PrintJob
-	if err := json.NewDecoder(r.Body).Decode(&job); err != nil {
-		http.Error(w, "Failed to decode job from request", http.StatusBadRequest)
+	clientID, requestID, err := decodeWriteBody(r, &job)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cmdData, err := encodePayload(job)
+	if err != nil {
+		http.Error(w, "Failed to encode print job command", http.StatusInternalServerError)
+		return
+	}
+	cmdBytes, err := encodeCommand(command{Op: "add_print_job", Data: cmdData, ClientID: clientID, RequestID: requestID})
+	if err != nil {
+		http.Error(w, "Failed to encode command", http.StatusInternalServerError)
 		return
 	}
-	cmdData, _ := json.Marshal(job)
-	cmd := command{Op: "add_print_job", Data: cmdData}
-	cmdBytes, _ := json.Marshal(cmd)
 	resp, err := s.store.Apply(cmdBytes)
 	if err != nil {
 		http.Error(w, "Failed to apply command to raft", http.StatusInternalServerError)
@@ -199,8 +359,7 @@ func (s *Server) handleUpdateJobStatus(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
-	if s.store.raft.State() != raft.Leader {
-		s.redirectToLeader(w, r)
+	if s.handleNonLeader(w, r) {
 		return
 	}
 	parts := strings.Split(r.URL.Path, "/")
@@ -215,9 +374,17 @@ func (s *Server) handleUpdateJobStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	updateData := UpdateJobStatusData{JobID: jobID, NewStatus: newStatus}
-	cmdData, _ := json.Marshal(updateData)
-	cmd := command{Op: "update_job_status", Data: cmdData}
-	cmdBytes, _ := json.Marshal(cmd)
+	cmdData, err := encodePayload(updateData)
+	if err != nil {
+		http.Error(w, "Failed to encode update job command", http.StatusInternalServerError)
+		return
+	}
+	clientID, requestID := r.Header.Get("X-Client-ID"), r.Header.Get("X-Request-ID")
+	cmdBytes, err := encodeCommand(command{Op: "update_job_status", Data: cmdData, ClientID: clientID, RequestID: requestID})
+	if err != nil {
+		http.Error(w, "Failed to encode command", http.StatusInternalServerError)
+		return
+	}
 	resp, err := s.store.Apply(cmdBytes)
 	if err != nil {
 		http.Error(w, "Failed to apply command to raft", http.StatusInternalServerError)
@@ -235,16 +402,27 @@ func (s *Server) handleJoin(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
+	if s.handleNonLeader(w, r) {
+		return
+	}
 	var req struct {
-		ID   string `json:"id"`
-		Addr string `json:"addr"`
+		ID       string `json:"id"`
+		Addr     string `json:"addr"`
+		HTTPAddr string `json:"http_addr"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Printf("Failed to decode join request: %s", err)
 		http.Error(w, "Failed to decode request", http.StatusBadRequest)
 		return
 	}
-	if err := s.store.Join(req.ID, req.Addr); err != nil {
+	voter := r.URL.Query().Get("voter") != "false"
+	var err error
+	if voter {
+		err = s.store.Join(req.ID, req.Addr, req.HTTPAddr)
+	} else {
+		err = s.store.AddNonvoter(req.ID, req.Addr, req.HTTPAddr)
+	}
+	if err != nil {
 		log.Printf("Failed to join node: %s", err)
 		http.Error(w, "Failed to join node", http.StatusInternalServerError)
 		return
@@ -252,6 +430,135 @@ func (s *Server) handleJoin(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+func (s *Server) handleRemove(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if s.handleNonLeader(w, r) {
+		return
+	}
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Failed to decode remove request: %s", err)
+		http.Error(w, "Failed to decode request", http.StatusBadRequest)
+		return
+	}
+	if err := s.store.RemoveServer(req.ID); err != nil {
+		log.Printf("Failed to remove node: %s", err)
+		http.Error(w, "Failed to remove node", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleDemote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if s.handleNonLeader(w, r) {
+		return
+	}
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Failed to decode demote request: %s", err)
+		http.Error(w, "Failed to decode request", http.StatusBadRequest)
+		return
+	}
+	if err := s.store.DemoteVoter(req.ID); err != nil {
+		log.Printf("Failed to demote node: %s", err)
+		http.Error(w, "Failed to demote node", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// clusterPeer is the JSON shape returned by GET /cluster for a single member.
+type clusterPeer struct {
+	ID       string `json:"id"`
+	Address  string `json:"address"`
+	Suffrage string `json:"suffrage"`
+}
+
+// clusterPeers converts a raft.Configuration into the /cluster response
+// shape, translating raft's Suffrage enum into the voter/nonvoter strings
+// the API exposes.
+func clusterPeers(config raft.Configuration) []clusterPeer {
+	peers := make([]clusterPeer, 0, len(config.Servers))
+	for _, srv := range config.Servers {
+		suffrage := "voter"
+		if srv.Suffrage == raft.Nonvoter {
+			suffrage = "nonvoter"
+		}
+		peers = append(peers, clusterPeer{ID: string(srv.ID), Address: string(srv.Address), Suffrage: suffrage})
+	}
+	return peers
+}
+
+func (s *Server) handleCluster(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	config, err := s.store.GetConfiguration()
+	if err != nil {
+		log.Printf("Failed to get configuration: %s", err)
+		http.Error(w, "Failed to get configuration", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(clusterPeers(config)); err != nil {
+		log.Printf("Failed to encode cluster configuration: %s", err)
+		http.Error(w, "Failed to encode cluster configuration", http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) handleBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="backup.json"`)
+	if err := s.store.Backup(w); err != nil {
+		log.Printf("Failed to write backup: %s", err)
+		http.Error(w, "Failed to write backup", http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) handleRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if s.handleNonLeader(w, r) {
+		return
+	}
+	if err := s.store.Restore(r.Body); err != nil {
+		log.Printf("Failed to restore: %s", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := s.store.Metrics().Render(w, s.store.raft.Stats()); err != nil {
+		log.Printf("Failed to render metrics: %s", err)
+		http.Error(w, "Failed to render metrics", http.StatusInternalServerError)
+	}
+}
+
 func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	stats := s.store.raft.Stats()
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestParseConsistencyDefaultsToWeak(t *testing.T) {
+	r := httptest.NewRequest("GET", "/printers", nil)
+	level, err := parseConsistency(r)
+	if err != nil {
+		t.Fatalf("parseConsistency returned error: %v", err)
+	}
+	if level != ConsistencyWeak {
+		t.Fatalf("expected default level %q, got %q", ConsistencyWeak, level)
+	}
+}
+
+func TestParseConsistencyAcceptsKnownLevels(t *testing.T) {
+	for _, level := range []ConsistencyLevel{ConsistencyNone, ConsistencyWeak, ConsistencyStrong} {
+		r := httptest.NewRequest("GET", "/printers?consistency="+string(level), nil)
+		got, err := parseConsistency(r)
+		if err != nil {
+			t.Fatalf("parseConsistency(%q) returned error: %v", level, err)
+		}
+		if got != level {
+			t.Fatalf("parseConsistency(%q) = %q, want %q", level, got, level)
+		}
+	}
+}
+
+func TestParseConsistencyRejectsUnknownLevel(t *testing.T) {
+	r := httptest.NewRequest("GET", "/printers?consistency=bogus", nil)
+	if _, err := parseConsistency(r); err == nil {
+		t.Fatal("expected an error for an unknown consistency level")
+	}
+}
+
+func TestParseMaxStaleAbsent(t *testing.T) {
+	r := httptest.NewRequest("GET", "/printers?consistency=none", nil)
+	d, ok, err := parseMaxStale(r)
+	if err != nil {
+		t.Fatalf("parseMaxStale returned error: %v", err)
+	}
+	if ok {
+		t.Fatalf("expected ok=false when max_stale is unset, got d=%v", d)
+	}
+}
+
+func TestParseMaxStaleParsesDuration(t *testing.T) {
+	r := httptest.NewRequest("GET", "/printers?consistency=none&max_stale=5s", nil)
+	d, ok, err := parseMaxStale(r)
+	if err != nil {
+		t.Fatalf("parseMaxStale returned error: %v", err)
+	}
+	if !ok || d != 5*time.Second {
+		t.Fatalf("parseMaxStale = (%v, %v), want (5s, true)", d, ok)
+	}
+}
+
+func TestParseMaxStaleRejectsInvalidDuration(t *testing.T) {
+	r := httptest.NewRequest("GET", "/printers?consistency=none&max_stale=soon", nil)
+	if _, _, err := parseMaxStale(r); err == nil {
+		t.Fatal("expected an error for an invalid max_stale duration")
+	}
+}
+
+func TestClusterPeersTranslatesSuffrage(t *testing.T) {
+	config := raft.Configuration{
+		Servers: []raft.Server{
+			{ID: "node1", Address: "10.0.0.1:8300", Suffrage: raft.Voter},
+			{ID: "node2", Address: "10.0.0.2:8300", Suffrage: raft.Nonvoter},
+		},
+	}
+	peers := clusterPeers(config)
+	if len(peers) != 2 {
+		t.Fatalf("expected 2 peers, got %d", len(peers))
+	}
+	if peers[0].ID != "node1" || peers[0].Address != "10.0.0.1:8300" || peers[0].Suffrage != "voter" {
+		t.Fatalf("unexpected peer[0]: %+v", peers[0])
+	}
+	if peers[1].ID != "node2" || peers[1].Address != "10.0.0.2:8300" || peers[1].Suffrage != "nonvoter" {
+		t.Fatalf("unexpected peer[1]: %+v", peers[1])
+	}
+}
+
+func TestClusterPeersEmptyConfiguration(t *testing.T) {
+	peers := clusterPeers(raft.Configuration{})
+	if len(peers) != 0 {
+		t.Fatalf("expected no peers, got %d", len(peers))
+	}
+}
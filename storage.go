@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/raft"
+	boltdb "github.com/hashicorp/raft-boltdb"
+)
+
+// StorageBackend supplies the raft.LogStore, raft.StableStore, and
+// raft.SnapshotStore a Store needs to open its Raft instance. Swapping the
+// implementation never touches the FSM, which only ever talks to raft.Raft.
+type StorageBackend interface {
+	LogStore() (raft.LogStore, error)
+	StableStore() (raft.StableStore, error)
+	SnapshotStore() (raft.SnapshotStore, error)
+}
+
+// BoltStorageBackend persists the log and stable stores as BoltDB files
+// under NodeDataDir, with filesystem snapshots alongside them. This is the
+// production backend and the one the server used unconditionally before
+// StorageBackend existed.
+type BoltStorageBackend struct {
+	NodeDataDir string
+}
+
+func (b *BoltStorageBackend) LogStore() (raft.LogStore, error) {
+	return boltdb.NewBoltStore(filepath.Join(b.NodeDataDir, "raft-log.db"))
+}
+
+func (b *BoltStorageBackend) StableStore() (raft.StableStore, error) {
+	return boltdb.NewBoltStore(filepath.Join(b.NodeDataDir, "raft-stable.db"))
+}
+
+func (b *BoltStorageBackend) SnapshotStore() (raft.SnapshotStore, error) {
+	return raft.NewFileSnapshotStore(b.NodeDataDir, 2, os.Stderr)
+}
+
+// MemoryStorageBackend keeps the log, stable, and snapshot stores entirely
+// in memory, with no fsyncs, so FSM tests run fast and leave nothing on
+// disk. It is not durable across process restarts.
+type MemoryStorageBackend struct{}
+
+func (MemoryStorageBackend) LogStore() (raft.LogStore, error) {
+	return raft.NewInmemStore(), nil
+}
+
+func (MemoryStorageBackend) StableStore() (raft.StableStore, error) {
+	return raft.NewInmemStore(), nil
+}
+
+func (MemoryStorageBackend) SnapshotStore() (raft.SnapshotStore, error) {
+	return raft.NewInmemSnapshotStore(), nil
+}
+
+// NewStorageBackend resolves a -storage flag value to a StorageBackend.
+// "" and "bolt" both select the durable BoltDB backend.
+func NewStorageBackend(kind, nodeDataDir string) (StorageBackend, error) {
+	switch kind {
+	case "", "bolt":
+		return &BoltStorageBackend{NodeDataDir: nodeDataDir}, nil
+	case "memory":
+		return MemoryStorageBackend{}, nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", kind)
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestNewStorageBackendMemory(t *testing.T) {
+	backend, err := NewStorageBackend("memory", t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStorageBackend returned error: %v", err)
+	}
+	if _, ok := backend.(MemoryStorageBackend); !ok {
+		t.Fatalf("expected MemoryStorageBackend, got %T", backend)
+	}
+}
+
+func TestNewStorageBackendUnknown(t *testing.T) {
+	if _, err := NewStorageBackend("sqlite", t.TempDir()); err == nil {
+		t.Fatal("expected an error for an unknown storage backend")
+	}
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
@@ -9,24 +11,53 @@ import (
 	"time"
 
 	"github.com/hashicorp/raft"
-	boltdb "github.com/hashicorp/raft-boltdb"
 )
 
+// ConsistencyLevel selects how a read is served relative to the Raft log,
+// mirroring the rqlite none/weak/strong model.
+type ConsistencyLevel string
+
+const (
+	// ConsistencyNone reads whatever the local FSM holds right now, without
+	// any Raft interaction. It is the only level servable from a follower.
+	ConsistencyNone ConsistencyLevel = "none"
+	// ConsistencyWeak requires the local node to be the current Raft leader
+	// but otherwise trusts its in-memory state. This is the default.
+	ConsistencyWeak ConsistencyLevel = "weak"
+	// ConsistencyStrong adds a barrier read on top of the leader check,
+	// guaranteeing the read observes every write committed before it began.
+	ConsistencyStrong ConsistencyLevel = "strong"
+)
+
+// barrierTimeout bounds how long a strong read waits for the barrier to
+// clear before giving up.
+const barrierTimeout = 5 * time.Second
+
 type Store struct {
 	NodeID   string
 	RaftAddr string
+	// HTTPAddr is this node's advertised HTTP API address. It is published
+	// to the rest of the cluster via RegisterNode once this node becomes
+	// leader, so followers can forward or redirect writes to it by address
+	// instead of assuming a fixed port offset from RaftAddr.
+	HTTPAddr string
 	DataDir  string
+	Storage  StorageBackend
 
 	raft *raft.Raft
 	fsm  *fsm
 }
 
-func NewStore(nodeID, raftAddr, dataDir string) *Store {
+// NewStore builds a Store that will use the given StorageBackend for its
+// Raft log, stable, and snapshot stores once Open is called.
+func NewStore(nodeID, raftAddr, httpAddr, dataDir string, storage StorageBackend) *Store {
 	return &Store{
 		NodeID:   nodeID,
 		RaftAddr: raftAddr,
+		HTTPAddr: httpAddr,
 		DataDir:  dataDir,
-		fsm:      newFSM(),
+		Storage:  storage,
+		fsm:      newFSM(newMetrics()),
 	}
 }
 
@@ -45,23 +76,25 @@ func (s *Store) Open(bootstrap bool) error {
 	if err != nil {
 		return err
 	}
-	logStore, err := boltdb.NewBoltStore(filepath.Join(nodeDataDir, "raft-log.db"))
+	logStore, err := s.Storage.LogStore()
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to open log store: %w", err)
 	}
-	stableStore, err := boltdb.NewBoltStore(filepath.Join(nodeDataDir, "raft-stable.db"))
+	stableStore, err := s.Storage.StableStore()
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to open stable store: %w", err)
 	}
-	snapshots, err := raft.NewFileSnapshotStore(nodeDataDir, 2, os.Stderr)
+	snapshots, err := s.Storage.SnapshotStore()
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to open snapshot store: %w", err)
 	}
 	r, err := raft.NewRaft(config, s.fsm, logStore, stableStore, snapshots, transport)
 	if err != nil {
 		return err
 	}
 	s.raft = r
+	s.watchObservations()
+	go s.publishHTTPAddrOnLeadership()
 	if bootstrap {
 		log.Println("Bootstrapping the cluster...")
 		configuration := raft.Configuration{
@@ -80,7 +113,80 @@ func (s *Store) Open(bootstrap bool) error {
 	return nil
 }
 
-func (s *Store) Join(nodeID, addr string) error {
+// watchObservations registers a Raft observer that turns leadership changes
+// and failed heartbeats into metric increments and log lines.
+func (s *Store) watchObservations() {
+	ch := make(chan raft.Observation, 16)
+	// blocking=false: a burst of observations that outruns this channel's
+	// consumer should drop events, not stall Raft's own internal processing
+	// waiting for a slot to free up.
+	s.raft.RegisterObserver(raft.NewObserver(ch, false, nil))
+	go func() {
+		for obs := range ch {
+			switch ev := obs.Data.(type) {
+			case raft.LeaderObservation:
+				log.Printf("Observed leadership change: leader is now %q", ev.Leader)
+				s.fsm.metrics.IncLeaderElections()
+			case raft.FailedHeartbeatObservation:
+				log.Printf("Observed failed heartbeat from peer %s", ev.PeerID)
+				s.fsm.metrics.IncFailedHeartbeats()
+			}
+		}
+	}()
+}
+
+// publishHTTPAddrOnLeadership replicates this node's advertised HTTP address
+// every time it becomes leader, so followers can resolve where to forward or
+// redirect writes without assuming a fixed port offset from RaftAddr.
+func (s *Store) publishHTTPAddrOnLeadership() {
+	for isLeader := range s.raft.LeaderCh() {
+		if !isLeader {
+			continue
+		}
+		if err := s.RegisterNode(s.RaftAddr, s.HTTPAddr); err != nil {
+			log.Printf("Failed to publish HTTP address for %s: %s", s.RaftAddr, err)
+		}
+	}
+}
+
+// RegisterNode records the advertised HTTP address a peer serves its API on,
+// replicating the mapping through Raft so every node, not just the leader,
+// can resolve it.
+func (s *Store) RegisterNode(raftAddr, httpAddr string) error {
+	if s.raft.State() != raft.Leader {
+		return fmt.Errorf("not the leader, cannot register node")
+	}
+	payload, err := encodePayload(RegisterNodeData{RaftAddr: raftAddr, HTTPAddr: httpAddr})
+	if err != nil {
+		return fmt.Errorf("failed to encode register_node data: %w", err)
+	}
+	cmdBytes, err := encodeCommand(command{Op: "register_node", Data: payload})
+	if err != nil {
+		return fmt.Errorf("failed to encode register_node command: %w", err)
+	}
+	resp, err := s.Apply(cmdBytes)
+	if err != nil {
+		return err
+	}
+	if fsmErr, ok := resp.(error); ok {
+		return fsmErr
+	}
+	return nil
+}
+
+// HTTPAddrFor looks up the advertised HTTP address a peer registered for the
+// given Raft address. It is used to forward or redirect writes to the real
+// leader endpoint.
+func (s *Store) HTTPAddrFor(raftAddr string) (string, bool) {
+	return s.fsm.HTTPAddrFor(raftAddr)
+}
+
+// Metrics returns the Store's metrics sink, used by the /metrics endpoint.
+func (s *Store) Metrics() *Metrics {
+	return s.fsm.metrics
+}
+
+func (s *Store) Join(nodeID, addr, httpAddr string) error {
 	if s.raft.State() != raft.Leader {
 		return fmt.Errorf("not the leader, cannot join")
 	}
@@ -91,20 +197,100 @@ func (s *Store) Join(nodeID, addr string) error {
 		return err
 	}
 	log.Printf("Node %s at %s joined successfully", nodeID, addr)
+	s.registerJoinedHTTPAddr(addr, httpAddr)
 	return nil
 }
 
-func (s *Store) Apply(cmdBytes []byte) error {
+// AddNonvoter adds a node to the cluster as a read-only replica that
+// receives the log but never participates in elections or quorum.
+func (s *Store) AddNonvoter(nodeID, addr, httpAddr string) error {
 	if s.raft.State() != raft.Leader {
-		return fmt.Errorf("not the leader, cannot apply command")
+		return fmt.Errorf("not the leader, cannot add nonvoter")
 	}
-	future := s.raft.Apply(cmdBytes, 500*time.Millisecond)
+	log.Printf("Received nonvoter join request for node %s at %s", nodeID, addr)
+	future := s.raft.AddNonvoter(raft.ServerID(nodeID), raft.ServerAddress(addr), 0, 0)
 	if err := future.Error(); err != nil {
-		return fmt.Errorf("failed to apply command: %w", err)
+		log.Printf("Failed to add nonvoter: %s", err)
+		return err
 	}
+	log.Printf("Node %s at %s joined as nonvoter", nodeID, addr)
+	s.registerJoinedHTTPAddr(addr, httpAddr)
 	return nil
 }
 
+// registerJoinedHTTPAddr publishes a newly joined peer's advertised HTTP
+// address, if it provided one, so write forwarding/redirects can reach it.
+// A failure here doesn't fail the join: the peer is already a cluster
+// member, it just won't be reachable as a write target until it re-registers
+// (e.g. the next time it becomes leader).
+func (s *Store) registerJoinedHTTPAddr(addr, httpAddr string) {
+	if httpAddr == "" {
+		return
+	}
+	if err := s.RegisterNode(addr, httpAddr); err != nil {
+		log.Printf("Failed to register HTTP address for %s: %s", addr, err)
+	}
+}
+
+// RemoveServer removes a node from the cluster configuration entirely,
+// used to retire a failed or decommissioned node.
+func (s *Store) RemoveServer(nodeID string) error {
+	if s.raft.State() != raft.Leader {
+		return fmt.Errorf("not the leader, cannot remove server")
+	}
+	log.Printf("Received remove request for node %s", nodeID)
+	future := s.raft.RemoveServer(raft.ServerID(nodeID), 0, 0)
+	if err := future.Error(); err != nil {
+		log.Printf("Failed to remove server %s: %s", nodeID, err)
+		return err
+	}
+	log.Printf("Node %s removed from the cluster", nodeID)
+	return nil
+}
+
+// DemoteVoter converts a voting member into a nonvoter without removing it
+// from the configuration, e.g. ahead of a planned retirement.
+func (s *Store) DemoteVoter(nodeID string) error {
+	if s.raft.State() != raft.Leader {
+		return fmt.Errorf("not the leader, cannot demote voter")
+	}
+	log.Printf("Received demote request for node %s", nodeID)
+	future := s.raft.DemoteVoter(raft.ServerID(nodeID), 0, 0)
+	if err := future.Error(); err != nil {
+		log.Printf("Failed to demote voter %s: %s", nodeID, err)
+		return err
+	}
+	log.Printf("Node %s demoted to nonvoter", nodeID)
+	return nil
+}
+
+// GetConfiguration returns the cluster's current membership list, including
+// each server's ID, address, and suffrage (voter/nonvoter).
+func (s *Store) GetConfiguration() (raft.Configuration, error) {
+	future := s.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return raft.Configuration{}, fmt.Errorf("failed to get configuration: %w", err)
+	}
+	return future.Configuration(), nil
+}
+
+// Apply commits cmdBytes to the Raft log and returns the FSM's result for
+// it once applied. The error return reflects only the Raft-commit outcome;
+// callers that need to know whether the command itself succeeded must type
+// -assert the returned value, since fsm.Apply reports business-rule failures
+// (e.g. "insufficient filament") by returning an error value rather than by
+// failing the Apply.
+func (s *Store) Apply(cmdBytes []byte) (interface{}, error) {
+	if s.raft.State() != raft.Leader {
+		return nil, fmt.Errorf("not the leader, cannot apply command")
+	}
+	future := s.raft.Apply(cmdBytes, 500*time.Millisecond)
+	if err := future.Error(); err != nil {
+		return nil, fmt.Errorf("failed to apply command: %w", err)
+	}
+	return future.Response(), nil
+}
+
 func (s *Store) GetPrinters() []Printer {
 	s.fsm.mu.Lock()
 	defer s.fsm.mu.Unlock()
@@ -124,3 +310,65 @@ func (s *Store) GetFilaments() []Filament {
 	}
 	return filaments
 }
+
+func (s *Store) GetPrintJobs() []PrintJob {
+	s.fsm.mu.Lock()
+	defer s.fsm.mu.Unlock()
+	jobs := make([]PrintJob, 0, len(s.fsm.data.PrintJobs))
+	for _, j := range s.fsm.data.PrintJobs {
+		jobs = append(jobs, j)
+	}
+	return jobs
+}
+
+// Backup streams a consistent snapshot of the FSM's data as JSON so an
+// operator can inspect or archive it; Restore accepts this same format back.
+func (s *Store) Backup(w io.Writer) error {
+	s.fsm.mu.Lock()
+	defer s.fsm.mu.Unlock()
+	if err := json.NewEncoder(w).Encode(s.fsm.data); err != nil {
+		return fmt.Errorf("failed to encode backup: %w", err)
+	}
+	return nil
+}
+
+// Restore replaces cluster state with the snapshot read from r. It is
+// leader-only: the payload is wrapped in a command{Op:"restore"} and
+// replicated through Raft so every follower atomically applies the same
+// replacement. The FSM rejects the restore if any print job is Running.
+func (s *Store) Restore(r io.Reader) error {
+	if s.raft.State() != raft.Leader {
+		return fmt.Errorf("not the leader, cannot restore")
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read restore payload: %w", err)
+	}
+	cmdBytes, err := encodeCommand(command{Op: "restore", Data: data})
+	if err != nil {
+		return fmt.Errorf("failed to encode restore command: %w", err)
+	}
+	resp, err := s.Apply(cmdBytes)
+	if err != nil {
+		return err
+	}
+	if fsmErr, ok := resp.(error); ok {
+		return fsmErr
+	}
+	return nil
+}
+
+// ConsistentRead satisfies the requested consistency level and then invokes
+// fn, returning its result. ConsistencyNone and ConsistencyWeak trust the
+// local FSM as-is; ConsistencyStrong first issues a Raft barrier so the read
+// observes every write committed before it began. Callers are responsible
+// for redirecting non-leaders away from ConsistencyWeak/ConsistencyStrong
+// reads before calling this, the same way write handlers already do.
+func (s *Store) ConsistentRead(level ConsistencyLevel, fn func() any) (any, error) {
+	if level == ConsistencyStrong {
+		if err := s.raft.Barrier(barrierTimeout).Error(); err != nil {
+			return nil, fmt.Errorf("barrier read failed: %w", err)
+		}
+	}
+	return fn(), nil
+}